@@ -5,16 +5,23 @@ import (
 
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
+	"path"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
 	"github.com/mackerelio/golib/logging"
+	"golang.org/x/sys/unix"
 )
 
 var logger = logging.GetLogger("metrics.plugin.network")
@@ -26,14 +33,94 @@ var (
 
 // file path
 const (
-	NetDev     = "/proc/net/dev"
-	NetNetstat = "/proc/net/netstat"
-	NetSnmp    = "/proc/net/snmp"
+	NetDev       = "/proc/net/dev"
+	NetNetstat   = "/proc/net/netstat"
+	NetSnmp      = "/proc/net/snmp"
+	NetSockstat  = "/proc/net/sockstat"
+	NetSockstat6 = "/proc/net/sockstat6"
 )
 
+// tcpStates maps the idiag_state values reported by NETLINK_INET_DIAG to the
+// labels already declared in the tcp.conn.state graph.
+var tcpStates = map[uint8]string{
+	1:  "ESTAB",
+	2:  "SYN-SENT",
+	3:  "SYN-RECV",
+	4:  "FIN-WAIT-1",
+	5:  "FIN-WAIT-2",
+	6:  "TIME-WAIT",
+	7:  "UNCONN",
+	8:  "CLOSE-WAIT",
+	9:  "LAST-ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+}
+
+// inetDiagSockIDSize is sizeof(struct inet_diag_sockid): sport(2) + dport(2) +
+// src(16) + dst(16) + if(4) + cookie(8).
+const inetDiagSockIDSize = 48
+
+// inetDiagReqV2 mirrors struct inet_diag_req_v2 from linux/inet_diag.h.
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       [inetDiagSockIDSize]byte
+}
+
+const (
+	sockDiagByFamily  = 20 // SOCK_DIAG_BY_FAMILY
+	inetDiagAllStates = 0xFFF
+)
+
+// ethtool ioctl commands, from linux/sockios.h and linux/ethtool.h.
+const (
+	siocEthtool  = 0x8946
+	ethtoolGset  = 0x00000001
+	ethtoolGlink = 0x0000000a
+)
+
+// ifreq mirrors struct ifreq, trimmed to the fields SIOCETHTOOL needs.
+type ifreq struct {
+	Name [unix.IFNAMSIZ]byte
+	Data uintptr
+}
+
+// ethtoolValue mirrors struct ethtool_value, used for ETHTOOL_GLINK.
+type ethtoolValue struct {
+	Cmd  uint32
+	Data uint32
+}
+
+// ethtoolCmd mirrors the legacy struct ethtool_cmd, used for ETHTOOL_GSET.
+type ethtoolCmd struct {
+	Cmd           uint32
+	Supported     uint32
+	Advertising   uint32
+	SpeedLo       uint16
+	Duplex        uint8
+	Port          uint8
+	PhyAddress    uint8
+	Transceiver   uint8
+	Autoneg       uint8
+	MdioSupport   uint8
+	Maxtxpkt      uint32
+	Maxrxpkt      uint32
+	SpeedHi       uint16
+	EthTpMdix     uint8
+	EthTpMdixCtrl uint8
+	LpAdvertising uint32
+	Reserved      [2]uint32
+}
+
 // NetworkPlugin mackerel plugin
 type NetworkPlugin struct {
-	Prefix string
+	Prefix          string
+	IncludeLoopback bool
+	Netns           string
+	MetricPatterns  []string
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -47,7 +134,7 @@ func (p *NetworkPlugin) MetricKeyPrefix() string {
 // GraphDefinition interface for mackerelplugin
 func (p *NetworkPlugin) GraphDefinition() map[string]mp.Graphs {
 	labelPrefix := strings.Title(p.Prefix)
-	return map[string]mp.Graphs{
+	graphs := map[string]mp.Graphs{
 		"interface.#": {
 			Label: labelPrefix + " Interface",
 			Unit:  "integer",
@@ -56,12 +143,30 @@ func (p *NetworkPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "rxErrors", Label: "rxErrors", Diff: true},
 				{Name: "rxDropped", Label: "rxDropped", Diff: true},
 				{Name: "rxOverruns", Label: "rxOverruns", Diff: true},
+				{Name: "rxMulticast", Label: "rxMulticast", Diff: true},
 				{Name: "txPackets", Label: "txPackets", Diff: true},
 				{Name: "txErrors", Label: "txErrors", Diff: true},
 				{Name: "txDropped", Label: "txDropped", Diff: true},
 				{Name: "txOverruns", Label: "txOverruns", Diff: true},
 			},
 		},
+		"interface.bytes.#": {
+			Label: labelPrefix + " Interface Bytes",
+			Unit:  "bytes/sec",
+			Metrics: []mp.Metrics{
+				{Name: "rxBytes", Label: "rxBytes", Diff: true},
+				{Name: "txBytes", Label: "txBytes", Diff: true},
+			},
+		},
+		"interface.link.#": {
+			Label: labelPrefix + " Interface Link",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "up", Label: "Up", Diff: false},
+				{Name: "speed", Label: "Speed(Mbps)", Diff: false},
+				{Name: "duplex", Label: "Duplex", Diff: false},
+			},
+		},
 		"ip.statistic": {
 			Label: labelPrefix + " IP Statistics",
 			Unit:  "integer",
@@ -110,29 +215,171 @@ func (p *NetworkPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "TcpExtSyncookiesFailed", Label: "Failed", Diff: true},
 			},
 		},
+		"tcp.sockets": {
+			Label: labelPrefix + " Tcp Sockets",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "tcp.inuse", Label: "InUse", Diff: false, Stacked: true},
+				{Name: "tcp.orphan", Label: "Orphan", Diff: false, Stacked: true},
+				{Name: "tcp.tw", Label: "TimeWait", Diff: false, Stacked: true},
+				{Name: "tcp.alloc", Label: "Alloc", Diff: false, Stacked: true},
+			},
+		},
+		"tcp.memory": {
+			Label: labelPrefix + " Tcp Memory",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "tcp.memBytes", Label: "Tcp", Diff: false},
+				{Name: "udp.memBytes", Label: "Udp", Diff: false},
+			},
+		},
+	}
+
+	if len(p.MetricPatterns) > 0 {
+		p.addExtendedGraphs(graphs, labelPrefix)
 	}
+	return graphs
+}
+
+// addExtendedGraphs adds tcp.extended/ip.extended graphs for the TcpExt/IpExt
+// counters in /proc/net/netstat selected by p.MetricPatterns, so operators
+// can opt into specific counters (e.g. "tcpext.*,ipext.InOctets") instead of
+// the fixed handful wired into the static graphs above.
+func (p *NetworkPlugin) addExtendedGraphs(graphs map[string]mp.Graphs, labelPrefix string) {
+	var tcpMetrics, ipMetrics []mp.Metrics
+	for _, header := range p.extendedHeaderNames(NetNetstat, "TcpExt") {
+		if alwaysCollectedExtMetrics["TcpExt"+header] || !p.metricSelected("TcpExt", header) {
+			continue
+		}
+		tcpMetrics = append(tcpMetrics, mp.Metrics{Name: "TcpExt" + header, Label: header, Diff: true})
+	}
+	for _, header := range p.extendedHeaderNames(NetNetstat, "IpExt") {
+		if alwaysCollectedExtMetrics["IpExt"+header] || !p.metricSelected("IpExt", header) {
+			continue
+		}
+		ipMetrics = append(ipMetrics, mp.Metrics{Name: "IpExt" + header, Label: header, Diff: true})
+	}
+
+	if len(tcpMetrics) > 0 {
+		graphs["tcp.extended"] = mp.Graphs{Label: labelPrefix + " Tcp Extended", Unit: "integer", Metrics: tcpMetrics}
+	}
+	if len(ipMetrics) > 0 {
+		graphs["ip.extended"] = mp.Graphs{Label: labelPrefix + " Ip Extended", Unit: "integer", Metrics: ipMetrics}
+	}
+}
+
+// extendedHeaderNames reads the header line for prefix (e.g. "TcpExt:") out
+// of a /proc/net/netstat-style file and returns the column names that follow
+// it, without requiring the matching values line.
+func (p *NetworkPlugin) extendedHeaderNames(filePath string, prefix string) []string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		logger.Warningf(err.Error())
+		return nil
+	}
+
+	var headers []string
+	for _, line := range bytes.Split(data, newLineByte) {
+		fields := bytes.Fields(line)
+		if len(fields) < 2 || string(bytes.TrimSuffix(fields[0], colonByte)) != prefix {
+			continue
+		}
+		if _, err := strconv.ParseFloat(string(fields[1]), 64); err == nil {
+			continue // this is the values line, not the header line
+		}
+		for _, h := range fields[1:] {
+			headers = append(headers, string(h))
+		}
+		break
+	}
+	return headers
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p *NetworkPlugin) FetchMetrics() (map[string]float64, error) {
 	metrics := make(map[string]float64)
 
+	restore, err := p.enterNetns()
+	if err != nil {
+		// Unlike the collectors below, failing to enter the requested netns
+		// means none of this invocation's metrics are for the namespace the
+		// operator asked for, so report it as a hard failure instead of a
+		// silent all-zero success.
+		return nil, err
+	}
+	defer restore()
+
 	if err := p.getProcDev(metrics); err != nil {
 		logger.Warningf(err.Error())
 	}
+	if err := p.getInterfaceLinks(metrics); err != nil {
+		logger.Warningf(err.Error())
+	}
 	if err := p.getProcNetstat(metrics); err != nil {
 		logger.Warningf(err.Error())
 	}
 	if err := p.getProcSnmp(metrics); err != nil {
 		logger.Warningf(err.Error())
 	}
-	if err := p.getNetworkStatistics(metrics); err != nil {
+	if err := p.getProcSockstat(metrics); err != nil {
+		logger.Warningf(err.Error())
+	}
+	if err := p.getTCPStatsNetlink(metrics); err != nil {
 		logger.Warningf(err.Error())
+		if err := p.getNetworkStatistics(metrics); err != nil {
+			logger.Warningf(err.Error())
+		}
 	}
 
 	return metrics, nil
 }
 
+// enterNetns switches the calling OS thread into p.Netns, if set, so that
+// the /proc/net/* reads and the netlink/ethtool collectors below report on
+// that namespace instead of the host's root netns. It returns a func that
+// restores the original netns; callers must defer it even when p.Netns is
+// empty, in which case it is a no-op.
+func (p *NetworkPlugin) enterNetns() (func(), error) {
+	if p.Netns == "" {
+		return func() {}, nil
+	}
+
+	path := p.Netns
+	if !strings.Contains(path, "/") {
+		path = "/var/run/netns/" + path
+	}
+
+	runtime.LockOSThread()
+
+	orig, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to open current netns: %s", err)
+	}
+
+	ns, err := os.Open(path)
+	if err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to open netns %s: %s", path, err)
+	}
+	defer ns.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to enter netns %s: %s", path, err)
+	}
+
+	return func() {
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			logger.Warningf("failed to restore original netns: %s", err)
+		}
+		orig.Close()
+		runtime.UnlockOSThread()
+	}, nil
+}
+
 func (p *NetworkPlugin) getProcDev(metrics map[string]float64) error {
 	file, err := os.Open(NetDev)
 	if err != nil {
@@ -173,6 +420,219 @@ func (p *NetworkPlugin) getNetworkStatistics(metrics map[string]float64) error {
 	return p.parseNetworkStatistics(metrics, out)
 }
 
+// getInterfaceLinks populates interface.link.# with per-interface link state,
+// negotiated speed and duplex, read via the ethtool ioctl. Interfaces that
+// don't support ethtool (veth, tun, bridges, ...) are skipped.
+func (p *NetworkPlugin) getInterfaceLinks(metrics map[string]float64) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	for _, iface := range ifaces {
+		if iface.Name == "lo" && !p.IncludeLoopback {
+			continue
+		}
+		if err := p.getInterfaceLink(metrics, fd, iface.Name); err != nil {
+			logger.Warningf("failed to get ethtool link info of %s: %s", iface.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *NetworkPlugin) getInterfaceLink(metrics map[string]float64, fd int, name string) error {
+	link := ethtoolValue{Cmd: ethtoolGlink}
+	if err := ethtoolIoctl(fd, name, unsafe.Pointer(&link)); err != nil {
+		if err == unix.EOPNOTSUPP {
+			return nil
+		}
+		return err
+	}
+	metrics["interface.link."+name+".up"] = float64(link.Data)
+
+	cmd := ethtoolCmd{Cmd: ethtoolGset}
+	if err := ethtoolIoctl(fd, name, unsafe.Pointer(&cmd)); err != nil {
+		if err == unix.EOPNOTSUPP {
+			return nil
+		}
+		return err
+	}
+
+	speed := uint32(cmd.SpeedHi)<<16 | uint32(cmd.SpeedLo)
+	if speed == 0xFFFF || speed == 0xFFFFFFFF {
+		speed = 0
+	}
+	metrics["interface.link."+name+".speed"] = float64(speed)
+	metrics["interface.link."+name+".duplex"] = float64(cmd.Duplex)
+	return nil
+}
+
+func ethtoolIoctl(fd int, name string, data unsafe.Pointer) error {
+	var ifr ifreq
+	copy(ifr.Name[:], name)
+	ifr.Data = uintptr(data)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(siocEthtool), uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (p *NetworkPlugin) getProcSockstat(metrics map[string]float64) error {
+	if err := p.collectSockstat(metrics, NetSockstat); err != nil {
+		return err
+	}
+	return p.collectSockstat(metrics, NetSockstat6)
+}
+
+func (p *NetworkPlugin) collectSockstat(metrics map[string]float64, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := p.parseProcSockstat(metrics, data); err != nil {
+		return err
+	}
+
+	pageSize := float64(syscall.Getpagesize())
+	if mem, ok := metrics["tcp.mem"]; ok {
+		metrics["tcp.memBytes"] = mem * pageSize
+	}
+	if mem, ok := metrics["udp.mem"]; ok {
+		metrics["udp.memBytes"] = mem * pageSize
+	}
+	return nil
+}
+
+// parseProcSockstat parses /proc/net/sockstat(6), where each line is a
+// protocol name followed by "key value" pairs, e.g.
+// "TCP: inuse 25 orphan 0 tw 0 alloc 27 mem 0". Metrics are keyed as
+// "<proto>.<key>" (lowercased), so IPv6 lines (TCP6, UDP6, ...) naturally
+// land under their own "tcp6.", "udp6." keys alongside the IPv4 ones.
+func (p *NetworkPlugin) parseProcSockstat(metrics map[string]float64, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		proto := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+		for i := 1; i+1 < len(fields); i += 2 {
+			key := strings.ToLower(fields[i])
+			value, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				logger.Warningf("failed to parse %s %s: %s", proto, key, err)
+				continue
+			}
+			metrics[proto+"."+key] = value
+		}
+	}
+	return scanner.Err()
+}
+
+// getTCPStatsNetlink counts TCP sockets per state via NETLINK_INET_DIAG,
+// covering both IPv4 and IPv6. It is preferred over getNetworkStatistics
+// because it avoids forking `ss` and isn't affected by its output format.
+//
+// Counts are accumulated into a local map and only merged into metrics once
+// both families have been collected successfully: FetchMetrics falls back to
+// getNetworkStatistics on error, and merging partial results first would
+// double-count whichever states the fallback also reports.
+func (p *NetworkPlugin) getTCPStatsNetlink(metrics map[string]float64) error {
+	counts := make(map[string]float64)
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		if err := p.collectTCPStatsNetlink(family, counts); err != nil {
+			return fmt.Errorf("failed to collect tcp stats via netlink: %s", err)
+		}
+	}
+	for state, count := range counts {
+		metrics[state] = count
+	}
+	return nil
+}
+
+func (p *NetworkPlugin) collectTCPStatsNetlink(family uint8, metrics map[string]float64) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM, unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	req := inetDiagReqV2{
+		Family:   family,
+		Protocol: unix.IPPROTO_TCP,
+		States:   inetDiagAllStates,
+	}
+
+	hdr := unix.NlMsghdr{
+		Len:   uint32(unix.SizeofNlMsghdr + binary.Size(req)),
+		Type:  sockDiagByFamily,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_DUMP,
+	}
+
+	msg := new(bytes.Buffer)
+	if err := binary.Write(msg, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	if err := binary.Write(msg, binary.LittleEndian, req); err != nil {
+		return err
+	}
+
+	if err := unix.Sendto(fd, msg.Bytes(), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	// A single inet_diag dump reply can span more than one page on hosts with
+	// many concurrent sockets; too small a buffer is silently truncated by
+	// the kernel with no error from Recvfrom, under-counting instead of
+	// failing loudly into the ss fallback.
+	buf := make([]byte, 16*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		replies, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		done := false
+		for _, reply := range replies {
+			switch reply.Header.Type {
+			case syscall.NLMSG_DONE:
+				done = true
+				continue
+			case syscall.NLMSG_ERROR:
+				return fmt.Errorf("netlink reply reported an error for family %d", family)
+			}
+			if len(reply.Data) < 2 {
+				continue
+			}
+			state, ok := tcpStates[reply.Data[1]]
+			if !ok {
+				state = "UNKNOWN"
+			}
+			metrics[state]++
+
+			if reply.Header.Flags&syscall.NLM_F_MULTI == 0 {
+				done = true
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
 func (p *NetworkPlugin) parseProcDev(metrics map[string]float64, out io.Reader) error {
 	scanner := bufio.NewScanner(out)
 	for scanner.Scan() {
@@ -185,9 +645,13 @@ func (p *NetworkPlugin) parseProcDev(metrics map[string]float64, out io.Reader)
 			continue
 		}
 		name := strings.TrimSpace(kv[0])
-		if name == "lo" {
+		if name == "lo" && !p.IncludeLoopback {
 			continue
 		}
+		rxBytes, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse rxBytes of %s", name)
+		}
 		rxPackets, err := strconv.ParseFloat(fields[1], 64)
 		if err != nil {
 			return fmt.Errorf("failed to parse rxPackets of %s", name)
@@ -204,6 +668,14 @@ func (p *NetworkPlugin) parseProcDev(metrics map[string]float64, out io.Reader)
 		if err != nil {
 			return fmt.Errorf("failed to parse rxOverruns of %s", name)
 		}
+		rxMulticast, err := strconv.ParseFloat(fields[7], 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse rxMulticast of %s", name)
+		}
+		txBytes, err := strconv.ParseFloat(fields[8], 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse txBytes of %s", name)
+		}
 		txPackets, err := strconv.ParseFloat(fields[9], 64)
 		if err != nil {
 			return fmt.Errorf("failed to parse txPackets of %s", name)
@@ -225,10 +697,13 @@ func (p *NetworkPlugin) parseProcDev(metrics map[string]float64, out io.Reader)
 		metrics["interface."+name+".rxErrors"] = rxErrors
 		metrics["interface."+name+".rxDropped"] = rxDropped
 		metrics["interface."+name+".rxOverruns"] = rxOverruns
+		metrics["interface."+name+".rxMulticast"] = rxMulticast
 		metrics["interface."+name+".txPackets"] = txPackets
 		metrics["interface."+name+".txErrors"] = txErrors
 		metrics["interface."+name+".txDropped"] = txDropped
 		metrics["interface."+name+".txOverruns"] = txOverruns
+		metrics["interface.bytes."+name+".rxBytes"] = rxBytes
+		metrics["interface.bytes."+name+".txBytes"] = txBytes
 	}
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("scan error for /proc/net/dev: %s", err)
@@ -236,32 +711,103 @@ func (p *NetworkPlugin) parseProcDev(metrics map[string]float64, out io.Reader)
 	return nil
 }
 
+// parseProcMetrics parses the header/value line pairs used by
+// /proc/net/netstat and /proc/net/snmp, e.g.:
+//
+//	TcpExt: SyncookiesSent SyncookiesRecv ...
+//	TcpExt: 0 0 ...
+//
+// Lines are keyed by their "Prefix:" field rather than assumed to alternate
+// at fixed offsets, so a header line and its values line may appear in any
+// order, and sections for different prefixes may be interleaved. A field
+// that fails to parse is logged and skipped rather than aborting the whole
+// file, so one malformed or kernel-added column doesn't poison the rest of
+// the metrics.
 func (p *NetworkPlugin) parseProcMetrics(metrics map[string]float64, data []byte) error {
-	// split the lines by newline
-	lines := bytes.Split(data, newLineByte)
-	// iterate over lines, take 2 lines each time
-	// first line contains header names
-	// second line contains values
-	for i := 0; i < len(lines); i = i + 2 {
-		if len(lines[i]) == 0 {
+	pendingHeaders := make(map[string][]string)
+
+	for _, line := range bytes.Split(data, newLineByte) {
+		fields := bytes.Fields(line)
+		if len(fields) < 2 {
 			continue
 		}
+		prefix := string(bytes.TrimSuffix(fields[0], colonByte))
 
-		headers := bytes.Fields(lines[i])
-		prefix := bytes.TrimSuffix(headers[0], colonByte)
-		values := bytes.Fields(lines[i+1])
+		if _, err := strconv.ParseFloat(string(fields[1]), 64); err != nil {
+			// This is a header line: remember it until its values line shows up.
+			headers := make([]string, len(fields)-1)
+			for i, h := range fields[1:] {
+				headers[i] = string(h)
+			}
+			pendingHeaders[prefix] = headers
+			continue
+		}
 
-		for j := 1; j < len(headers); j++ {
-			value, err := strconv.ParseFloat(string(values[j]), 64)
+		headers, ok := pendingHeaders[prefix]
+		if !ok {
+			logger.Warningf("no header seen for %s values line, skipping", prefix)
+			continue
+		}
+		values := fields[1:]
+		for i, header := range headers {
+			if i >= len(values) {
+				break
+			}
+			if !p.metricSelected(prefix, header) {
+				continue
+			}
+			value, err := strconv.ParseFloat(string(values[i]), 64)
 			if err != nil {
-				return err
+				logger.Warningf("failed to parse %s%s: %s", prefix, header, err)
+				continue
 			}
-			metrics[string(prefix)+string(headers[j])] = value
+			metrics[prefix+header] = value
 		}
 	}
 	return nil
 }
 
+// alwaysCollectedExtMetrics are the TcpExt/IpExt fields already wired into
+// the static ip.statistic/tcp.backlog/tcp.syncookie graphs. -metrics is an
+// allowlist for the extra counters those graphs don't cover, not a filter
+// over metrics the plugin already exposes unconditionally, so these must
+// keep flowing regardless of p.MetricPatterns.
+var alwaysCollectedExtMetrics = map[string]bool{
+	"IpExtInCsumErrors":      true,
+	"TcpExtTCPBacklogDrop":   true,
+	"TcpExtSyncookiesFailed": true,
+}
+
+// metricSelected reports whether the TcpExt/IpExt metric named prefix+header
+// should be collected. Every other prefix (Tcp, Ip, Icmp, Udp, ...) is always
+// collected, matching the plugin's behavior before -metrics existed; TcpExt
+// and IpExt carry hundreds of counters, so they're only collected when they
+// match one of p.MetricPatterns (or when no patterns were configured, in
+// which case everything is collected as before). A pattern may be prefixed
+// with "!" to exclude a previously matched counter.
+func (p *NetworkPlugin) metricSelected(prefix, header string) bool {
+	if prefix != "TcpExt" && prefix != "IpExt" {
+		return true
+	}
+	if alwaysCollectedExtMetrics[prefix+header] {
+		return true
+	}
+	if len(p.MetricPatterns) == 0 {
+		return true
+	}
+
+	key := strings.ToLower(prefix + "." + header)
+	selected := false
+	for _, pattern := range p.MetricPatterns {
+		deny := strings.HasPrefix(pattern, "!")
+		glob := strings.ToLower(strings.TrimPrefix(pattern, "!"))
+		if ok, _ := path.Match(glob, key); ok {
+			selected = !deny
+		}
+	}
+	return selected
+}
+
 func (p *NetworkPlugin) parseNetworkStatistics(metrics map[string]float64, out io.Reader) error {
 	scanner := bufio.NewScanner(out)
 	for scanner.Scan() {
@@ -276,13 +822,36 @@ func (p *NetworkPlugin) parseNetworkStatistics(metrics map[string]float64, out i
 	return nil
 }
 
+// parseMetricPatterns splits a comma separated -metrics flag value into its
+// glob patterns, trimming spaces and dropping empty entries. The patterns are
+// kept as plain strings; they are not precompiled, and metricSelected
+// lowercases and matches each one with path.Match on every call.
+func parseMetricPatterns(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	var patterns []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			patterns = append(patterns, part)
+		}
+	}
+	return patterns
+}
+
 // Do the plugin
 func Do() {
 	optPrefix := flag.String("metric-key-prefix", "", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optIncludeLoopback := flag.Bool("include-loopback", false, "Include the loopback interface")
+	optNetns := flag.String("netns", "", "Network namespace name or path to scope metrics to")
+	optMetrics := flag.String("metrics", "", "Comma separated glob patterns (e.g. tcpext.*,ipext.InOctets, prefix with ! to exclude) selecting which TcpExt/IpExt counters to report")
 	flag.Parse()
 	plugin := mp.NewMackerelPlugin(&NetworkPlugin{
-		Prefix: *optPrefix,
+		Prefix:          *optPrefix,
+		IncludeLoopback: *optIncludeLoopback,
+		Netns:           *optNetns,
+		MetricPatterns:  parseMetricPatterns(*optMetrics),
 	})
 	plugin.Tempfile = *optTempfile
 	plugin.Run()
@@ -1,6 +1,7 @@
 package mpnetwork
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,4 +63,190 @@ UdpLite: 0 0 0 0 0 0 0`
 	assert.EqualValues(t, metrics["TcpRtoAlgorithm"], 1)
 	assert.EqualValues(t, metrics["UdpInDatagrams"], 418)
 	assert.EqualValues(t, metrics["UdpLiteInDatagrams"], 0)
+}
+
+func TestParseProcMetricsInterleavedSections(t *testing.T) {
+
+	str := `TcpExt: SyncookiesSent SyncookiesRecv
+IpExt: InNoRoutes InTruncatedPkts
+TcpExt: 1 2
+IpExt: 3 4`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcMetrics(metrics, []byte(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["TcpExtSyncookiesSent"], 1)
+	assert.EqualValues(t, metrics["TcpExtSyncookiesRecv"], 2)
+	assert.EqualValues(t, metrics["IpExtInNoRoutes"], 3)
+	assert.EqualValues(t, metrics["IpExtInTruncatedPkts"], 4)
+}
+
+func TestParseProcMetricsSkipsBadField(t *testing.T) {
+
+	str := `TcpExt: SyncookiesSent SyncookiesRecv SyncookiesFailed
+TcpExt: 1 bad 3`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcMetrics(metrics, []byte(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["TcpExtSyncookiesSent"], 1)
+	_, ok := metrics["TcpExtSyncookiesRecv"]
+	assert.False(t, ok)
+	assert.EqualValues(t, metrics["TcpExtSyncookiesFailed"], 3)
+}
+
+func TestParseProcMetricsValuesBeforeHeader(t *testing.T) {
+
+	str := `TcpExt: 1 2
+TcpExt: SyncookiesSent SyncookiesRecv
+TcpExt: 3 4`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcMetrics(metrics, []byte(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["TcpExtSyncookiesSent"], 3)
+	assert.EqualValues(t, metrics["TcpExtSyncookiesRecv"], 4)
+}
+
+func TestMetricSelected(t *testing.T) {
+
+	p := NetworkPlugin{}
+	assert.True(t, p.metricSelected("TcpExt", "SyncookiesSent"))
+	assert.True(t, p.metricSelected("Tcp", "RtoAlgorithm"))
+
+	p = NetworkPlugin{MetricPatterns: []string{"tcpext.*", "!tcpext.syncookiessent"}}
+	assert.True(t, p.metricSelected("TcpExt", "SyncookiesRecv"))
+	assert.False(t, p.metricSelected("TcpExt", "SyncookiesSent"))
+	assert.False(t, p.metricSelected("IpExt", "InNoRoutes"))
+	// Prefixes other than TcpExt/IpExt are never filtered, even with patterns set.
+	assert.True(t, p.metricSelected("Tcp", "RtoAlgorithm"))
+
+	p = NetworkPlugin{MetricPatterns: []string{"ipext.inoctets"}}
+	assert.True(t, p.metricSelected("IpExt", "InOctets"))
+	assert.False(t, p.metricSelected("IpExt", "OutOctets"))
+
+	// Metrics already wired into the static ip.statistic/tcp.backlog/tcp.syncookie
+	// graphs must keep flowing even when -metrics doesn't mention them.
+	p = NetworkPlugin{MetricPatterns: []string{"tcpext.syncookiessent"}}
+	assert.True(t, p.metricSelected("IpExt", "InCsumErrors"))
+	assert.True(t, p.metricSelected("TcpExt", "TCPBacklogDrop"))
+	assert.True(t, p.metricSelected("TcpExt", "SyncookiesFailed"))
+}
+
+func TestParseMetricPatterns(t *testing.T) {
+
+	assert.Equal(t, []string(nil), parseMetricPatterns(""))
+	assert.Equal(t, []string{"tcpext.*", "ipext.InOctets"}, parseMetricPatterns("tcpext.*, ipext.InOctets"))
+	assert.Equal(t, []string{"a", "b"}, parseMetricPatterns("a,,  b ,"))
+}
+
+func TestParseProcDev(t *testing.T) {
+
+	str := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 190471    1583    0    0    0     0          0         0   190876    1583    0    0    0     0       0          0
+  eth0: 2816293    8626    1    2    3     4          5         6  1050381    6670    7    8    9    10      11         12`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcDev(metrics, strings.NewReader(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := metrics["interface.lo.rxPackets"]
+	assert.False(t, ok, "lo should be skipped when IncludeLoopback is false")
+
+	assert.EqualValues(t, metrics["interface.bytes.eth0.rxBytes"], 2816293)
+	assert.EqualValues(t, metrics["interface.eth0.rxPackets"], 8626)
+	assert.EqualValues(t, metrics["interface.eth0.rxErrors"], 1)
+	assert.EqualValues(t, metrics["interface.eth0.rxDropped"], 2)
+	assert.EqualValues(t, metrics["interface.eth0.rxOverruns"], 3)
+	assert.EqualValues(t, metrics["interface.eth0.rxMulticast"], 6)
+	assert.EqualValues(t, metrics["interface.bytes.eth0.txBytes"], 1050381)
+	assert.EqualValues(t, metrics["interface.eth0.txPackets"], 6670)
+	assert.EqualValues(t, metrics["interface.eth0.txErrors"], 7)
+	assert.EqualValues(t, metrics["interface.eth0.txDropped"], 8)
+	assert.EqualValues(t, metrics["interface.eth0.txOverruns"], 9)
+}
+
+func TestParseProcDevIncludeLoopback(t *testing.T) {
+
+	str := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 190471    1583    0    0    0     0          0         0   190876    1583    0    0    0     0       0          0`
+
+	p := NetworkPlugin{IncludeLoopback: true}
+	metrics := make(map[string]float64)
+
+	err := p.parseProcDev(metrics, strings.NewReader(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["interface.bytes.lo.rxBytes"], 190471)
+	assert.EqualValues(t, metrics["interface.lo.rxPackets"], 1583)
+}
+
+func TestParseProcSockstat(t *testing.T) {
+
+	str := `sockets: used 287
+TCP: inuse 25 orphan 0 tw 0 alloc 27 mem 0
+UDP: inuse 12 mem 4
+UDPLITE: inuse 0
+RAW: inuse 0
+FRAG: inuse 0 memory 0`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcSockstat(metrics, []byte(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["sockets.used"], 287)
+	assert.EqualValues(t, metrics["tcp.inuse"], 25)
+	assert.EqualValues(t, metrics["tcp.orphan"], 0)
+	assert.EqualValues(t, metrics["tcp.tw"], 0)
+	assert.EqualValues(t, metrics["tcp.alloc"], 27)
+	assert.EqualValues(t, metrics["tcp.mem"], 0)
+	assert.EqualValues(t, metrics["udp.inuse"], 12)
+	assert.EqualValues(t, metrics["udp.mem"], 4)
+	assert.EqualValues(t, metrics["frag.memory"], 0)
+}
+
+func TestParseProcSockstat6(t *testing.T) {
+
+	str := `TCP6: inuse 8
+UDP6: inuse 5
+FRAG6: inuse 0`
+
+	var p NetworkPlugin
+	metrics := make(map[string]float64)
+
+	err := p.parseProcSockstat(metrics, []byte(str))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualValues(t, metrics["tcp6.inuse"], 8)
+	assert.EqualValues(t, metrics["udp6.inuse"], 5)
+	assert.EqualValues(t, metrics["frag6.inuse"], 0)
 }
\ No newline at end of file